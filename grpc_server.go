@@ -0,0 +1,271 @@
+package main
+
+//go:generate protoc --go_out=. --go-grpc_out=. proto/bank.proto
+
+import (
+ "context"
+ "errors"
+ "fmt"
+ "net"
+ "time"
+
+ bankpb "github.com/ulapogodina/Bankivskoe-prilogenie/proto"
+ "google.golang.org/grpc"
+ "google.golang.org/grpc/codes"
+ "google.golang.org/grpc/metadata"
+ "google.golang.org/grpc/status"
+)
+
+// customerMetadataKey - ключ метаданных запроса, по которому определяется
+// вызывающий клиент. Без него запрос отклоняется как Unauthenticated.
+const customerMetadataKey = "customer"
+
+type customerContextKey struct{}
+
+// grpcServer реализует bankpb.BankServiceServer поверх существующего BankApp.
+type grpcServer struct {
+ bankpb.UnimplementedBankServiceServer
+ app *BankApp
+}
+
+func newGRPCServer(app *BankApp) *grpcServer {
+ return &grpcServer{app: app}
+}
+
+// ServeGRPC поднимает gRPC-сервер BankService на addr и блокируется, пока он
+// не остановится. Каждый вызов проходит через customerUnaryInterceptor,
+// который аутентифицирует клиента по метаданным "customer".
+func ServeGRPC(addr string, app *BankApp) error {
+ lis, err := net.Listen("tcp", addr)
+ if err != nil {
+  return fmt.Errorf("прослушивание %s: %w", addr, err)
+ }
+
+ server := grpc.NewServer(
+  grpc.UnaryInterceptor(customerUnaryInterceptor),
+  grpc.StreamInterceptor(customerStreamInterceptor),
+ )
+ bankpb.RegisterBankServiceServer(server, newGRPCServer(app))
+
+ return server.Serve(lis)
+}
+
+// customerUnaryInterceptor требует метаданные "customer" на каждом обычном
+// запросе и кладет значение клиента в контекст для обработчиков.
+func customerUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+ customerID, err := customerFromContext(ctx)
+ if err != nil {
+  return nil, err
+ }
+ return handler(context.WithValue(ctx, customerContextKey{}, customerID), req)
+}
+
+// customerStreamInterceptor делает то же самое для стримингового RPC
+// (WatchTransactions).
+func customerStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+ customerID, err := customerFromContext(ss.Context())
+ if err != nil {
+  return err
+ }
+ return handler(srv, &customerServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), customerContextKey{}, customerID)})
+}
+
+type customerServerStream struct {
+ grpc.ServerStream
+ ctx context.Context
+}
+
+func (s *customerServerStream) Context() context.Context {
+ return s.ctx
+}
+
+func customerFromContext(ctx context.Context) (string, error) {
+ md, ok := metadata.FromIncomingContext(ctx)
+ if !ok {
+  return "", status.Error(codes.Unauthenticated, "отсутствуют метаданные запроса")
+ }
+ values := md.Get(customerMetadataKey)
+ if len(values) == 0 || values[0] == "" {
+  return "", status.Error(codes.Unauthenticated, "метаданные customer обязательны")
+ }
+ return values[0], nil
+}
+
+// authorizeAccount загружает счет и проверяет, что он принадлежит
+// аутентифицированному клиенту из ctx.
+func (s *grpcServer) authorizeAccount(ctx context.Context, accountID string) (*Account, error) {
+ customerID, _ := ctx.Value(customerContextKey{}).(string)
+
+ account, err := s.app.FindAccount(accountID)
+ if err != nil {
+  if errors.Is(err, ErrAccountNotFound) {
+   return nil, status.Error(codes.NotFound, err.Error())
+  }
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ if account.CustomerID != customerID {
+  return nil, status.Error(codes.PermissionDenied, "счет принадлежит другому клиенту")
+ }
+ return account, nil
+}
+
+func (s *grpcServer) OpenAccount(ctx context.Context, req *bankpb.OpenAccountRequest) (*bankpb.Account, error) {
+ customerID, _ := ctx.Value(customerContextKey{}).(string)
+
+ accountType, err := parseAccountType(req.GetType())
+ if err != nil {
+  return nil, status.Error(codes.InvalidArgument, err.Error())
+ }
+
+ account, err := s.app.CreateAccountForCustomer(customerID, req.GetOwner(), accountType, req.GetCreditLimit())
+ if err != nil {
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return s.toAccountPB(account)
+}
+
+func (s *grpcServer) CloseAccount(ctx context.Context, req *bankpb.CloseAccountRequest) (*bankpb.CloseAccountResponse, error) {
+ account, err := s.authorizeAccount(ctx, req.GetAccountId())
+ if err != nil {
+  return nil, err
+ }
+ if err := s.app.CloseAccount(account.ID); err != nil {
+  if errors.Is(err, ErrAccountHasBalance) {
+   return nil, status.Error(codes.FailedPrecondition, err.Error())
+  }
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return &bankpb.CloseAccountResponse{Closed: true}, nil
+}
+
+func (s *grpcServer) Deposit(ctx context.Context, req *bankpb.DepositRequest) (*bankpb.Account, error) {
+ account, err := s.authorizeAccount(ctx, req.GetAccountId())
+ if err != nil {
+  return nil, err
+ }
+ if err := s.app.Deposit(account.ID, req.GetAmount()); err != nil {
+  return nil, status.Error(codes.InvalidArgument, err.Error())
+ }
+ return s.toAccountPB(account)
+}
+
+func (s *grpcServer) Withdraw(ctx context.Context, req *bankpb.WithdrawRequest) (*bankpb.Account, error) {
+ account, err := s.authorizeAccount(ctx, req.GetAccountId())
+ if err != nil {
+  return nil, err
+ }
+ if err := s.app.Withdraw(account.ID, req.GetAmount()); err != nil {
+  return nil, status.Error(codes.FailedPrecondition, err.Error())
+ }
+ return s.toAccountPB(account)
+}
+
+func (s *grpcServer) Transfer(ctx context.Context, req *bankpb.TransferRequest) (*bankpb.TransferResponse, error) {
+ if _, err := s.authorizeAccount(ctx, req.GetFromAccountId()); err != nil {
+  return nil, err
+ }
+
+ order, err := s.app.Transfer(ctx, req.GetFromAccountId(), req.GetToAccountId(), req.GetAmount())
+ if err != nil && order == nil {
+  // order == nil значит, что BankApp.Transfer отклонил запрос еще до
+  // создания заявки (см. BankApp.Transfer) - в этих случаях ошибка вызвана
+  // некорректными входными данными клиента, а не сбоем сервера.
+  if errors.Is(err, ErrInvalidAmount) || errors.Is(err, ErrSameAccountTransfer) {
+   return nil, status.Error(codes.InvalidArgument, err.Error())
+  }
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return &bankpb.TransferResponse{OrderId: order.ID, Status: string(order.Status)}, nil
+}
+
+func (s *grpcServer) GetBalance(ctx context.Context, req *bankpb.GetBalanceRequest) (*bankpb.GetBalanceResponse, error) {
+ account, err := s.authorizeAccount(ctx, req.GetAccountId())
+ if err != nil {
+  return nil, err
+ }
+ balance, err := s.app.GetBalance(account.ID)
+ if err != nil {
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return &bankpb.GetBalanceResponse{Balance: balance}, nil
+}
+
+func (s *grpcServer) GetStatement(ctx context.Context, req *bankpb.GetStatementRequest) (*bankpb.GetStatementResponse, error) {
+ account, err := s.authorizeAccount(ctx, req.GetAccountId())
+ if err != nil {
+  return nil, err
+ }
+ statement, err := s.app.GetStatement(account.ID)
+ if err != nil {
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return &bankpb.GetStatementResponse{Statement: statement}, nil
+}
+
+// watchPollInterval - период опроса хранилища на предмет новых транзакций.
+// Хранилище (InMemoryStorage/SQLStorage) не умеет публиковать события, поэтому
+// WatchTransactions реализован через поллинг, а не push-уведомления.
+const watchPollInterval = 500 * time.Millisecond
+
+func (s *grpcServer) WatchTransactions(req *bankpb.WatchTransactionsRequest, stream bankpb.BankService_WatchTransactionsServer) error {
+ account, err := s.authorizeAccount(stream.Context(), req.GetAccountId())
+ if err != nil {
+  return err
+ }
+
+ postings, err := s.app.ReplayLedger(account.ID)
+ if err != nil {
+  return status.Error(codes.Internal, err.Error())
+ }
+ lastSeen := len(postings)
+
+ ticker := time.NewTicker(watchPollInterval)
+ defer ticker.Stop()
+
+ for {
+  select {
+  case <-stream.Context().Done():
+   return stream.Context().Err()
+  case <-ticker.C:
+   postings, err := s.app.ReplayLedger(account.ID)
+   if err != nil {
+    return status.Error(codes.Internal, err.Error())
+   }
+   for _, posting := range postings[lastSeen:] {
+    if err := stream.Send(toTransactionPB(posting, account.ID)); err != nil {
+     return err
+    }
+   }
+   lastSeen = len(postings)
+  }
+ }
+}
+
+func (s *grpcServer) toAccountPB(account *Account) (*bankpb.Account, error) {
+ balance, err := s.app.GetBalance(account.ID)
+ if err != nil {
+  return nil, status.Error(codes.Internal, err.Error())
+ }
+ return &bankpb.Account{
+  Id:      account.ID,
+  Owner:   account.Owner,
+  Balance: balance,
+  Type:    string(account.Type),
+ }, nil
+}
+
+// toTransactionPB проецирует проводку ledger на наблюдаемый счет accountID:
+// sign показывает, был ли accountID кредитуемой ("+") или дебетуемой ("-")
+// стороной проводки, по аналогии с BankApp.GetStatement.
+func toTransactionPB(posting Posting, accountID string) *bankpb.Transaction {
+ sign := "+"
+ if posting.DebitAccountID == accountID {
+  sign = "-"
+ }
+ return &bankpb.Transaction{
+  Sign:          sign,
+  Amount:        amountFromCents(posting.AmountCents),
+  TimestampUnix: posting.Timestamp.Unix(),
+  ReferenceId:   posting.ReferenceID,
+ }
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+ "context"
  "errors"
+ "flag"
  "fmt"
+ "log"
+ "os"
  "strconv"
+ "strings"
+ "sync"
  "time"
 )
 
@@ -13,152 +19,141 @@ var (
  ErrInvalidAmount       = errors.New("некорректная сумма (отрицательная или нулевая)")
  ErrAccountNotFound     = errors.New("счет не найден")
  ErrSameAccountTransfer = errors.New("попытка перевода на тот же счёт")
+ ErrUnknownAccountType  = errors.New("неизвестный тип счета")
+ // ErrDirectLoanDeposit - Loan-счета нельзя пополнять напрямую, только
+ // вносить платежи в счет основного долга через MakeLoanPayment.
+ ErrDirectLoanDeposit = errors.New("пополнение Loan-счета напрямую запрещено, используйте платеж по кредиту")
+ // ErrAccountHasBalance - нельзя закрыть счет, пока на нем остаются средства.
+ ErrAccountHasBalance = errors.New("нельзя закрыть счет с ненулевым балансом")
+ // ErrAccountClosed - счет закрыт (см. BankApp.CloseAccount) и не принимает
+ // новые проводки.
+ ErrAccountClosed = errors.New("счет закрыт")
 )
 
-// Интерфейс для работы со счетом
-type AccountService interface {
- Deposit(amount float64) error
- Withdraw(amount float64) error
- Transfer(to *Account, amount float64) error
- GetBalance() float64
- GetStatement() string
-}
-
-// Интерфейс для работы с хранилищем
-type Storage interface {
- SaveAccount(account *Account) error
- LoadAccount(accountID string) (*Account, error)
- GetAllAccounts() ([]*Account, error)
-}
-
-// Тип транзакции
-type TransactionType string
+// AccountType - тип счета в терминах двойной записи. Определяет, может ли
+// счет уходить в минус (кредитные счета) и на сколько.
+type AccountType string
 
 const (
- Deposit  TransactionType = "DEPOSIT"
- Withdraw TransactionType = "WITHDRAW"
- Transfer TransactionType = "TRANSFER"
+ AccountTypeChecking     AccountType = "CHECKING"
+ AccountTypeSaving       AccountType = "SAVING"
+ AccountTypeMoneyMarket  AccountType = "MONEY_MARKET"
+ AccountTypeLineOfCredit AccountType = "LINE_OF_CREDIT"
+ AccountTypeLoan         AccountType = "LOAN"
+ AccountTypeEquities     AccountType = "EQUITIES"
 )
 
-// Структура транзакции
-type Transaction struct {
- Type      TransactionType
- Amount    float64
- Timestamp time.Time
- Message   string
+// isCredit сообщает, что счет кредитного типа: ему разрешено уходить в минус
+// в пределах CreditLimit. Обычные (активные) счета в минус уходить не могут.
+func (t AccountType) isCredit() bool {
+ return t == AccountTypeLineOfCredit || t == AccountTypeLoan
 }
 
-// Структура счета
-type Account struct {
- ID           string
- Owner        string
- Balance      float64
- Transactions []Transaction
-}
-
-// Реализация методов AccountService для Account
-func (a *Account) Deposit(amount float64) error {
- if amount <= 0 {
-  return ErrInvalidAmount
+func parseAccountType(s string) (AccountType, error) {
+ switch AccountType(strings.ToUpper(strings.TrimSpace(s))) {
+ case AccountTypeChecking:
+  return AccountTypeChecking, nil
+ case AccountTypeSaving:
+  return AccountTypeSaving, nil
+ case AccountTypeMoneyMarket:
+  return AccountTypeMoneyMarket, nil
+ case AccountTypeLineOfCredit:
+  return AccountTypeLineOfCredit, nil
+ case AccountTypeLoan:
+  return AccountTypeLoan, nil
+ case AccountTypeEquities:
+  return AccountTypeEquities, nil
+ default:
+  return "", fmt.Errorf("%w: %q", ErrUnknownAccountType, s)
  }
-
- a.Balance += amount
- a.Transactions = append(a.Transactions, Transaction{
-  Type:      Deposit,
-  Amount:    amount,
-  Timestamp: time.Now(),
-  Message:   fmt.Sprintf("Пополнение: +%.2f", amount),
- })
- return nil
-}
-
-func (a *Account) Withdraw(amount float64) error {
- if amount <= 0 {
-  return ErrInvalidAmount
- }
- if amount > a.Balance {
-  return ErrInsufficientFunds
- }
-
- a.Balance -= amount
- a.Transactions = append(a.Transactions, Transaction{
-  Type:      Withdraw,
-  Amount:    amount,
-  Timestamp: time.Now(),
-  Message:   fmt.Sprintf("Снятие: -%.2f", amount),
- })
- return nil
 }
 
-func (a *Account) Transfer(to *Account, amount float64) error {
- if amount <= 0 {
-  return ErrInvalidAmount
- }
- if a.ID == to.ID {
-  return ErrSameAccountTransfer
- }
- if amount > a.Balance {
-  return ErrInsufficientFunds
- }
-
- a.Balance -= amount
- to.Balance += amount
-
- // Запись транзакции для отправителя
- a.Transactions = append(a.Transactions, Transaction{
-  Type:      Transfer,
-  Amount:    amount,
-  Timestamp: time.Now(),
-  Message:   fmt.Sprintf("Перевод на счет %s: -%.2f", to.ID, amount),
- })
-
- // Запись транзакции для получателя
- to.Transactions = append(to.Transactions, Transaction{
-  Type:      Transfer,
-  Amount:    amount,
-  Timestamp: time.Now(),
-  Message:   fmt.Sprintf("Перевод от счета %s: +%.2f", a.ID, amount),
- })
-
- return nil
+// externalAccountID - псевдо-счет, представляющий внешний мир (наличные,
+// внешние переводы). Deposit/Withdraw оформляются проводкой между реальным
+// счетом и этим псевдо-счетом, чтобы баланс оставался проекцией Ledger, а не
+// отдельным мутируемым полем. У externalAccountID нет записи в Storage и нет
+// ограничений на баланс.
+const externalAccountID = "EXTERNAL"
+
+// Posting - одна проводка двойной записи: AmountCents переходит со счета
+// DebitAccountID на счет CreditAccountID. Постинги добавляются только в конец
+// (append-only); баланс счета - это сумма кредитов минус сумма дебетов по его
+// проводкам (см. BankApp.GetBalance), а не отдельное хранимое поле.
+type Posting struct {
+ ID              string
+ DebitAccountID  string
+ CreditAccountID string
+ AmountCents     int64
+ Timestamp       time.Time
+ ReferenceID     string
 }
 
-func (a *Account) GetBalance() float64 {
- return a.Balance
+// Интерфейс для работы с хранилищем
+type Storage interface {
+ SaveAccount(account *Account) error
+ LoadAccount(accountID string) (*Account, error)
+ GetAllAccounts() ([]*Account, error)
+ // AppendPostingIdempotent добавляет проводку в ledger, если проводки с таким
+ // же ReferenceID еще не существует (applied=true), либо молча пропускает
+ // повтор (applied=false) - на этом построена идемпотентность саги переводов
+ // (transfer.go) и скриптов (script_runner.go).
+ AppendPostingIdempotent(posting Posting) (applied bool, err error)
+ // AppendPostingsIdempotent проводит postings атомарно - либо фиксируются
+ // все, либо (при ошибке на любой из них) ни одна. Используется там, где
+ // несколько проводок одного запуска должны разделить судьбу (скрипты, см.
+ // script_runner.go); сага переводов (transfer.go) продвигается по одной
+ // проводке через AppendPostingIdempotent, потому что ее шаги по смыслу
+ // независимо идемпотентны и восстанавливаемы.
+ AppendPostingsIdempotent(postings []Posting) error
+ // ListPostings возвращает все проводки, где accountID выступает дебетуемым
+ // или кредитуемым счетом, в порядке добавления.
+ ListPostings(accountID string) ([]Posting, error)
+ // Close освобождает ресурсы хранилища (соединение с БД и т.п.). Для
+ // хранилищ в памяти это no-op.
+ Close() error
 }
 
-func (a *Account) GetStatement() string {
- if len(a.Transactions) == 0 {
-  return "История транзакций пуста"
- }
-
- statement := "Выписка по счету:\n"
- statement += fmt.Sprintf("Владелец: %s\n", a.Owner)
- statement += fmt.Sprintf("Номер счета: %s\n", a.ID)
- statement += fmt.Sprintf("Текущий баланс: %.2f\n\n", a.Balance)
- statement += "История транзакций:\n"
-
- for i, tx := range a.Transactions {
-  statement += fmt.Sprintf("%d. %s [%s]\n", i+1, tx.Message, tx.Timestamp.Format("2006-01-02 15:04:05"))
- }
-
- return statement
+// Структура счета. Баланс не хранится на счете - он вычисляется как проекция
+// по Ledger (см. BankApp.GetBalance), поэтому здесь только метаданные.
+type Account struct {
+ ID    string
+ Owner string
+ // CustomerID - идентификатор клиента-владельца счета в терминах gRPC API
+ // (см. grpc_server.go). Для счетов, созданных через TUI, остается пустым -
+ // доступ к ним из TUI не разграничивается по клиентам.
+ CustomerID string
+ Type       AccountType
+ // CreditLimit - насколько в минус может уйти счет кредитного типа
+ // (Type.isCredit()). Для активных счетов (Checking, Saving, ...) не
+ // используется - они не могут уйти в минус вовсе.
+ CreditLimit float64
+ // Closed помечает счет, закрытый через BankApp.CloseAccount. Закрытый счет
+ // остается видимым (баланс и выписка по-прежнему читаются из ledger), но
+ // не принимает новые проводки.
+ Closed bool
 }
 
 // InMemoryStorage реализация хранилища в памяти
 type InMemoryStorage struct {
+ mu       sync.Mutex
  accounts map[string]*Account
  nextID   int
+ postings []Posting
+ byRef    map[string]bool
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
  return &InMemoryStorage{
   accounts: make(map[string]*Account),
   nextID:   1,
+  byRef:    make(map[string]bool),
  }
 }
 
 func (s *InMemoryStorage) SaveAccount(account *Account) error {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
  if account.ID == "" {
   account.ID = strconv.Itoa(s.nextID)
   s.nextID++
@@ -168,6 +163,9 @@ func (s *InMemoryStorage) SaveAccount(account *Account) error {
 }
 
 func (s *InMemoryStorage) LoadAccount(accountID string) (*Account, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
  account, exists := s.accounts[accountID]
  if !exists {
   return nil, ErrAccountNotFound
@@ -176,28 +174,98 @@ func (s *InMemoryStorage) LoadAccount(accountID string) (*Account, error) {
 }
 
 func (s *InMemoryStorage) GetAllAccounts() ([]*Account, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
  accounts := make([]*Account, 0, len(s.accounts))
- for _, account := range
-  nge s.accounts {
+ for _, account := range s.accounts {
   accounts = append(accounts, account)
  }
  return accounts, nil
 }
 
+func (s *InMemoryStorage) AppendPostingIdempotent(posting Posting) (bool, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ if posting.ReferenceID != "" && s.byRef[posting.ReferenceID] {
+  return false, nil
+ }
+ s.postings = append(s.postings, posting)
+ if posting.ReferenceID != "" {
+  s.byRef[posting.ReferenceID] = true
+ }
+ return true, nil
+}
+
+func (s *InMemoryStorage) AppendPostingsIdempotent(postings []Posting) error {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ for _, posting := range postings {
+  if posting.ReferenceID != "" && s.byRef[posting.ReferenceID] {
+   continue
+  }
+  s.postings = append(s.postings, posting)
+  if posting.ReferenceID != "" {
+   s.byRef[posting.ReferenceID] = true
+  }
+ }
+ return nil
+}
+
+func (s *InMemoryStorage) ListPostings(accountID string) ([]Posting, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ var postings []Posting
+ for _, p := range s.postings {
+  if p.DebitAccountID == accountID || p.CreditAccountID == accountID {
+   postings = append(postings, p)
+  }
+ }
+ return postings, nil
+}
+
+func (s *InMemoryStorage) Close() error {
+ return nil
+}
+
 // BankApp - основное приложение
 type BankApp struct {
- storage Storage
+ storage       Storage
+ transferStore TransferStore
+ // postingMu сериализует составные операции "прочитать баланс -> проверить
+ // лимит -> дописать проводку" (postLeg, ledgerAdapter.ApplyPostings).
+ // Storage гарантирует атомарность лишь отдельного вызова, а не всей такой
+ // последовательности - без этой блокировки два одновременных
+ // Withdraw/Transfer по одному счету (gRPC-сервер обслуживает запросы
+ // параллельно, см. grpc_server.go) могли бы оба прочитать один и тот же
+ // баланс и вместе увести счет глубже лимита.
+ postingMu sync.Mutex
 }
 
-func NewBankApp(storage Storage) *BankApp {
- return &BankApp{storage: storage}
+func NewBankApp(storage Storage, transferStore TransferStore) *BankApp {
+ return &BankApp{storage: storage, transferStore: transferStore}
 }
 
-func (app *BankApp) CreateAccount(owner string) (*Account, error) {
+func (app *BankApp) CreateAccount(owner string, accountType AccountType, creditLimit float64) (*Account, error) {
+ return app.createAccount("", owner, accountType, creditLimit)
+}
+
+// CreateAccountForCustomer открывает счет, привязанный к клиенту customerID.
+// Используется gRPC API (см. grpc_server.go), где доступ к счету затем
+// проверяется по CustomerID из аутентифицированных метаданных запроса.
+func (app *BankApp) CreateAccountForCustomer(customerID, owner string, accountType AccountType, creditLimit float64) (*Account, error) {
+ return app.createAccount(customerID, owner, accountType, creditLimit)
+}
+
+func (app *BankApp) createAccount(customerID, owner string, accountType AccountType, creditLimit float64) (*Account, error) {
  account := &Account{
-  Owner:        owner,
-  Balance:      0,
-  Transactions: []Transaction{},
+  Owner:       owner,
+  CustomerID:  customerID,
+  Type:        accountType,
+  CreditLimit: creditLimit,
  }
  err := app.storage.SaveAccount(account)
  if err != nil {
@@ -210,13 +278,260 @@ func (app *BankApp) FindAccount(accountID string) (*Account, error) {
  return app.storage.LoadAccount(accountID)
 }
 
+// CloseAccount закрывает accountID, если его баланс нулевой. Закрытый счет
+// остается доступным для чтения (GetBalance, GetStatement, ReplayLedger), но
+// postLeg отклоняет любые новые проводки по нему как ErrAccountClosed.
+func (app *BankApp) CloseAccount(accountID string) error {
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return err
+ }
+ if account.Closed {
+  return nil
+ }
+
+ balance, err := app.GetBalance(accountID)
+ if err != nil {
+  return err
+ }
+ if balance != 0 {
+  return ErrAccountHasBalance
+ }
+
+ account.Closed = true
+ return app.storage.SaveAccount(account)
+}
+
 func (app *BankApp) GetAllAccounts() ([]*Account, error) {
  return app.storage.GetAllAccounts()
 }
 
+// GetBalance проецирует баланс счета из ledger: сумма проводок, где счет
+// выступает кредитуемым, минус сумма проводок, где он выступает дебетуемым.
+func (app *BankApp) GetBalance(accountID string) (float64, error) {
+ if _, err := app.storage.LoadAccount(accountID); err != nil {
+  return 0, err
+ }
+
+ postings, err := app.storage.ListPostings(accountID)
+ if err != nil {
+  return 0, err
+ }
+
+ var cents int64
+ for _, p := range postings {
+  if p.CreditAccountID == accountID {
+   cents += p.AmountCents
+  }
+  if p.DebitAccountID == accountID {
+   cents -= p.AmountCents
+  }
+ }
+ return amountFromCents(cents), nil
+}
+
+// ReplayLedger возвращает все проводки счета в порядке их применения - это и
+// есть полный аудиторский след счета.
+func (app *BankApp) ReplayLedger(accountID string) ([]Posting, error) {
+ return app.storage.ListPostings(accountID)
+}
+
+// GetStatement строит человекочитаемую выписку по счету на основе ledger.
+func (app *BankApp) GetStatement(accountID string) (string, error) {
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return "", err
+ }
+ postings, err := app.ReplayLedger(accountID)
+ if err != nil {
+  return "", err
+ }
+ balance, err := app.GetBalance(accountID)
+ if err != nil {
+  return "", err
+ }
+
+ if len(postings) == 0 {
+  return "История транзакций пуста", nil
+ }
+
+ statement := "Выписка по счету:\n"
+ statement += fmt.Sprintf("Владелец: %s\n", account.Owner)
+ statement += fmt.Sprintf("Номер счета: %s\n", account.ID)
+ statement += fmt.Sprintf("Тип счета: %s\n", account.Type)
+ statement += fmt.Sprintf("Текущий баланс: %.2f\n\n", balance)
+ statement += "История проводок:\n"
+
+ for i, p := range postings {
+  sign := "+"
+  if p.DebitAccountID == accountID {
+   sign = "-"
+  }
+  statement += fmt.Sprintf("%d. %s%.2f [%s]\n", i+1, sign, amountFromCents(p.AmountCents), p.Timestamp.Format("2006-01-02 15:04:05"))
+ }
+
+ return statement, nil
+}
+
+// Deposit зачисляет amount на accountID проводкой от externalAccountID. Loan-
+// счета пополнять так нельзя - см. ErrDirectLoanDeposit и MakeLoanPayment.
+func (app *BankApp) Deposit(accountID string, amount float64) error {
+ if amount <= 0 {
+  return ErrInvalidAmount
+ }
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return err
+ }
+ if account.Type == AccountTypeLoan {
+  return ErrDirectLoanDeposit
+ }
+ return app.postLeg(externalAccountID, accountID, amount, generateReferenceID())
+}
+
+// Withdraw списывает amount с accountID проводкой в пользу externalAccountID,
+// с учетом ограничений типа счета (см. checkWithdrawable).
+func (app *BankApp) Withdraw(accountID string, amount float64) error {
+ if amount <= 0 {
+  return ErrInvalidAmount
+ }
+ return app.postLeg(accountID, externalAccountID, amount, generateReferenceID())
+}
+
+// MakeLoanPayment - единственный способ пополнить Loan-счет: списывает amount
+// с fromAccountID (как обычное списание) и зачисляет его в счет основного
+// долга loanAccountID.
+func (app *BankApp) MakeLoanPayment(fromAccountID, loanAccountID string, amount float64) error {
+ if amount <= 0 {
+  return ErrInvalidAmount
+ }
+ loan, err := app.storage.LoadAccount(loanAccountID)
+ if err != nil {
+  return err
+ }
+ if loan.Type != AccountTypeLoan {
+  return fmt.Errorf("счет %s не является кредитным счетом (Loan)", loanAccountID)
+ }
+ return app.postLeg(fromAccountID, loanAccountID, amount, generateReferenceID())
+}
+
+// postLeg проводит amount от debitAccountID к creditAccountID под данным
+// referenceID (идемпотентно - см. Storage.AppendPostingIdempotent). Баланс
+// дебетуемой стороны проверяется с учетом типа счета, если это не
+// externalAccountID (у него ограничений на баланс нет). postingMu
+// сериализует проверку и дописывание проводки в одну атомарную
+// последовательность (см. BankApp.postingMu).
+func (app *BankApp) postLeg(debitAccountID, creditAccountID string, amount float64, referenceID string) error {
+ app.postingMu.Lock()
+ defer app.postingMu.Unlock()
+
+ if debitAccountID != externalAccountID {
+  if err := app.checkWithdrawable(debitAccountID, amount); err != nil {
+   return err
+  }
+ }
+ if creditAccountID != externalAccountID {
+  if err := app.ensureAccountOpen(creditAccountID); err != nil {
+   return err
+  }
+ }
+
+ _, err := app.storage.AppendPostingIdempotent(Posting{
+  ID:              referenceID,
+  DebitAccountID:  debitAccountID,
+  CreditAccountID: creditAccountID,
+  AmountCents:     centsFromAmount(amount),
+  Timestamp:       time.Now(),
+  ReferenceID:     referenceID,
+ })
+ return err
+}
+
+// checkWithdrawable проверяет, что списание amount с accountID не нарушит
+// ограничение его типа счета: активные счета (Checking, Saving, ...) не могут
+// уйти в минус, кредитные (LineOfCredit, Loan) - могут, но не глубже
+// -CreditLimit.
+func (app *BankApp) checkWithdrawable(accountID string, amount float64) error {
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return err
+ }
+ if account.Closed {
+  return ErrAccountClosed
+ }
+ balance, err := app.GetBalance(accountID)
+ if err != nil {
+  return err
+ }
+
+ newBalance := balance - amount
+ limit := 0.0
+ if account.Type.isCredit() {
+  limit = -account.CreditLimit
+ }
+ if newBalance < limit {
+  return ErrInsufficientFunds
+ }
+ return nil
+}
+
+// ensureAccountOpen проверяет, что accountID не закрыт через CloseAccount -
+// закрытый счет не может быть кредитуемой стороной проводки (debit-сторону
+// уже покрывает checkWithdrawable).
+func (app *BankApp) ensureAccountOpen(accountID string) error {
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return err
+ }
+ if account.Closed {
+  return ErrAccountClosed
+ }
+ return nil
+}
+
+// checkDepositable проверяет, что accountID может быть кредитуемой стороной
+// проводки: счет не закрыт и не является Loan-счетом (его нельзя пополнять
+// напрямую - см. ErrDirectLoanDeposit и MakeLoanPayment). То же самое ограничение,
+// что и на пути Deposit/postLeg, но как отдельная проверка для вызывающих,
+// которым нужно провалидировать несколько проводок до их применения (см.
+// ledgerAdapter.ApplyPostings в script_runner.go).
+func (app *BankApp) checkDepositable(accountID string) error {
+ account, err := app.storage.LoadAccount(accountID)
+ if err != nil {
+  return err
+ }
+ if account.Closed {
+  return ErrAccountClosed
+ }
+ if account.Type == AccountTypeLoan {
+  return ErrDirectLoanDeposit
+ }
+ return nil
+}
+
 func main() {
- storage := NewInMemoryStorage()
- bankApp := NewBankApp(storage)
+ if len(os.Args) > 1 && os.Args[1] == "serve" {
+  runServe(os.Args[2:])
+  return
+ }
+
+ storageKind := flag.String("storage", "memory", "хранилище счетов: memory|sqlite|postgres")
+ dsn := flag.String("dsn", "", "строка подключения для -storage=sqlite|postgres")
+ flag.Parse()
+
+ storage, err := newStorage(*storageKind, *dsn)
+ if err != nil {
+  log.Fatalf("не удалось инициализировать хранилище %q: %v", *storageKind, err)
+ }
+ defer storage.Close()
+
+ ctx := context.Background()
+ transferStore := NewInMemoryTransferStore()
+ bankApp := NewBankApp(storage, transferStore)
+
+ if err := bankApp.Resume(ctx); err != nil {
+  fmt.Printf("Ошибка восстановления незавершенных переводов: %v\n", err)
+ }
 
  for {
   fmt.Println("\n=== Банковское приложение ===")
@@ -228,6 +543,7 @@ func main() {
   fmt.Println("6. Получить выписку")
   fmt.Println("7. Список всех счетов")
   fmt.Println("8. Выйти")
+  fmt.Println("9. Выполнить скрипт")
   fmt.Print("Выберите действие: ")
 
   var choice int
@@ -241,7 +557,7 @@ func main() {
   case 3:
    appWithdraw(bankApp)
   case 4:
-   appTransfer(bankApp)
+   appTransfer(ctx, bankApp)
   case 5:
    appGetBalance(bankApp)
   case 6:
@@ -251,6 +567,8 @@ func main() {
   case 8:
    fmt.Println("До свидания!")
    return
+  case 9:
+   appRunScript(bankApp)
   default:
    fmt.Println("Неверный выбор")
   }
@@ -262,7 +580,23 @@ func appCreateAccount(bankApp *BankApp) {
  fmt.Print("Введите имя владельца счета: ")
  fmt.Scan(&owner)
 
- account, err := bankApp.CreateAccount(owner)
+ var typeInput string
+ fmt.Print("Тип счета (CHECKING|SAVING|MONEY_MARKET|LINE_OF_CREDIT|LOAN|EQUITIES): ")
+ fmt.Scan(&typeInput)
+
+ accountType, err := parseAccountType(typeInput)
+ if err != nil {
+  fmt.Printf("Ошибка создания счета: %v\n", err)
+  return
+ }
+
+ var creditLimit float64
+ if accountType.isCredit() {
+  fmt.Print("Кредитный лимит (на сколько счет может уйти в минус): ")
+  fmt.Scan(&creditLimit)
+ }
+
+ account, err := bankApp.CreateAccount(owner, accountType, creditLimit)
  if err != nil {
   fmt.Printf("Ошибка создания счета: %v\n", err)
   return
@@ -271,10 +605,11 @@ func appCreateAccount(bankApp *BankApp) {
  fmt.Printf("Счет успешно создан!\n")
  fmt.Printf("Номер счета: %s\n", account.ID)
  fmt.Printf("Владелец: %s\n", account.Owner)
+ fmt.Printf("Тип счета: %s\n", account.Type)
 }
 
 func appDeposit(bankApp *BankApp) {
- account, err := getAccount(bankApp)
+ accountID, err := readAccountID(bankApp)
  if err != nil {
   return
  }
@@ -283,18 +618,17 @@ func appDeposit(bankApp *BankApp) {
  fmt.Print("Введите сумму для пополнения: ")
  fmt.Scan(&amount)
 
- err = account.Deposit(amount)
- if err != nil {
+ if err := bankApp.Deposit(accountID, amount); err != nil {
   fmt.Printf("Ошибка пополнения: %v\n", err)
   return
  }
 
- bankApp.storage.SaveAccount(account)
- fmt.Printf("Счет успешно пополнен на %.2f. Новый баланс: %.2f\n", amount, account.GetBalance())
+ balance, _ := bankApp.GetBalance(accountID)
+ fmt.Printf("Счет успешно пополнен на %.2f. Новый баланс: %.2f\n", amount, balance)
 }
 
 func appWithdraw(bankApp *BankApp) {
- account, err := getAccount(bankApp)
+ accountID, err := readAccountID(bankApp)
  if err != nil {
   return
  }
@@ -303,18 +637,17 @@ func appWithdraw(bankApp *BankApp) {
  fmt.Print("Введите сумму для снятия: ")
  fmt.Scan(&amount)
 
- err = account.Withdraw(amount)
- if err != nil {
+ if err := bankApp.Withdraw(accountID, amount); err != nil {
   fmt.Printf("Ошибка снятия: %v\n", err)
   return
  }
 
- bankApp.storage.SaveAccount(account)
- fmt.Printf("Со счета снято %.2f. Новый баланс: %.2f\n", amount, account.GetBalance())
+ balance, _ := bankApp.GetBalance(accountID)
+ fmt.Printf("Со счета снято %.2f. Новый баланс: %.2f\n", amount, balance)
 }
 
-func appTransfer(bankApp *BankApp) {
- fromAccount, err := getAccount(bankApp)
+func appTransfer(ctx context.Context, bankApp *BankApp) {
+ fromAccountID, err := readAccountID(bankApp)
  if err != nil {
   return
  }
@@ -323,43 +656,50 @@ func appTransfer(bankApp *BankApp) {
  fmt.Print("Введите номер счета получателя: ")
  fmt.Scan(&toAccountID)
 
- toAccount, err := bankApp.FindAccount(toAccountID)
- if err != nil {
-  fmt.Printf("Ошибка поиска счета получателя: %v\n", err)
-  return
- }
-
  var amount float64
  fmt.Print("Введите сумму для перевода: ")
  fmt.Scan(&amount)
 
- err = fromAccount.Transfer(toAccount, amount)
+ order, err := bankApp.Transfer(ctx, fromAccountID, toAccountID, amount)
  if err != nil {
   fmt.Printf("Ошибка перевода: %v\n", err)
   return
  }
 
- bankApp.storage.SaveAccount(fromAccount)
- bankApp.storage.SaveAccount(toAccount)
- fmt.Printf("Перевод успешно выполнен! Переведено %.2f на счет %s\n", amount, toAccountID)
+ switch order.Status {
+ case TransferSucceeded:
+  fmt.Printf("Перевод успешно выполнен! Переведено %.2f на счет %s\n", amount, toAccountID)
+ default:
+  fmt.Printf("Перевод не завершен (заявка %s, статус %s)\n", order.ID, order.Status)
+ }
 }
 
 func appGetBalance(bankApp *BankApp) {
- account, err := getAccount(bankApp)
+ accountID, err := readAccountID(bankApp)
  if err != nil {
   return
  }
 
- fmt.Printf("Текущий баланс: %.2f\n", account.GetBalance())
+ balance, err := bankApp.GetBalance(accountID)
+ if err != nil {
+  fmt.Printf("Ошибка получения баланса: %v\n", err)
+  return
+ }
+ fmt.Printf("Текущий баланс: %.2f\n", balance)
 }
 
 func appGetStatement(bankApp *BankApp) {
- account, err := getAccount(bankApp)
+ accountID, err := readAccountID(bankApp)
  if err != nil {
   return
  }
 
- fmt.Println(account.GetStatement())
+ statement, err := bankApp.GetStatement(accountID)
+ if err != nil {
+  fmt.Printf("Ошибка получения выписки: %v\n", err)
+  return
+ }
+ fmt.Println(statement)
 }
 
 func appListAccounts(bankApp *BankApp) {
@@ -376,21 +716,56 @@ func appListAccounts(bankApp *BankApp) {
 
  fmt.Println("Список всех счетов:")
  for _, account := range accounts {
-  fmt.Printf("Счет: %s, Владелец: %s, Баланс: %.2f\n", 
-   account.ID, account.Owner, account.Balance)
+  balance, err := bankApp.GetBalance(account.ID)
+  if err != nil {
+   fmt.Printf("Счет: %s, Владелец: %s, Тип: %s, Баланс: ошибка (%v)\n",
+    account.ID, account.Owner, account.Type, err)
+   continue
+  }
+  fmt.Printf("Счет: %s, Владелец: %s, Тип: %s, Баланс: %.2f\n",
+   account.ID, account.Owner, account.Type, balance)
  }
 }
 
-func getAccount(bankApp *BankApp) (*Account, error) {
+// readAccountID запрашивает номер счета и проверяет, что он существует -
+// обработчикам меню после этого нужен только сам ID, а не полный объект
+// Account (баланс и выписка - это проекции по ledger, см. BankApp.GetBalance).
+func readAccountID(bankApp *BankApp) (string, error) {
  var accountID string
  fmt.Print("Введите номер счета: ")
  fmt.Scan(&accountID)
 
- account, err := bankApp.FindAccount(accountID)
- if err != nil {
+ if _, err := bankApp.FindAccount(accountID); err != nil {
   fmt.Printf("Ошибка поиска счета: %v\n", err)
-  return nil, err
+  return "", err
  }
+ return accountID, nil
+}
 
- return account, nil
+// runServe обрабатывает подкоманду "serve": поднимает gRPC-сервер вместо
+// интерактивного TUI, используя тот же BankApp и флаги хранилища.
+func runServe(args []string) {
+ fs := flag.NewFlagSet("serve", flag.ExitOnError)
+ addr := fs.String("addr", ":50051", "адрес, на котором слушает gRPC-сервер")
+ storageKind := fs.String("storage", "memory", "хранилище счетов: memory|sqlite|postgres")
+ dsn := fs.String("dsn", "", "строка подключения для -storage=sqlite|postgres")
+ fs.Parse(args)
+
+ storage, err := newStorage(*storageKind, *dsn)
+ if err != nil {
+  log.Fatalf("не удалось инициализировать хранилище %q: %v", *storageKind, err)
+ }
+ defer storage.Close()
+
+ transferStore := NewInMemoryTransferStore()
+ bankApp := NewBankApp(storage, transferStore)
+
+ if err := bankApp.Resume(context.Background()); err != nil {
+  log.Printf("ошибка восстановления незавершенных переводов: %v", err)
+ }
+
+ log.Printf("gRPC-сервер запущен на %s", *addr)
+ if err := ServeGRPC(*addr, bankApp); err != nil {
+  log.Fatalf("gRPC-сервер остановлен с ошибкой: %v", err)
+ }
 }
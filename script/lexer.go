@@ -0,0 +1,62 @@
+package script
+
+import (
+ "strings"
+ "unicode"
+)
+
+type tokenKind int
+
+const (
+ tokEOF tokenKind = iota
+ tokIdent
+ tokNumber
+ tokSymbol
+)
+
+type token struct {
+ kind tokenKind
+ text string
+}
+
+// lex разбивает исходный текст скрипта на токены: идентификаторы/ключевые
+// слова, числа и односимвольные символы ([ ] ( ) { } = @ : % ). Пробелы и
+// переносы строк - только разделители, скрипт не чувствителен к форматированию.
+func lex(src string) []token {
+ var tokens []token
+ runes := []rune(src)
+
+ for i := 0; i < len(runes); {
+  r := runes[i]
+  switch {
+  case unicode.IsSpace(r):
+   i++
+
+  case unicode.IsLetter(r) || r == '_':
+   start := i
+   for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+    i++
+   }
+   tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i])})
+
+  case unicode.IsDigit(r):
+   start := i
+   for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+    i++
+   }
+   tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i])})
+
+  case strings.ContainsRune("[](){}=@:%", r):
+   tokens = append(tokens, token{kind: tokSymbol, text: string(r)})
+   i++
+
+  default:
+   // Неизвестный символ молча пропускается, как пробел. Если без него
+   // токены не складываются в скрипт, parser вернет ошибку о неожиданном
+   // токене; иначе скрипт разбирается так, будто символа не было.
+   i++
+  }
+ }
+
+ return tokens
+}
@@ -0,0 +1,176 @@
+package script
+
+import (
+ "crypto/rand"
+ "encoding/hex"
+ "fmt"
+ "math/big"
+)
+
+// Posting - одна проводка, порожденная исполнением SEND: перевод AmountCents
+// минорных единиц Currency со счета Source на счет Destination. Все проводки
+// одного запуска скрипта помечены общим ScriptRunID.
+type Posting struct {
+ ScriptRunID string
+ Source      string
+ Destination string
+ Currency    string
+ AmountCents int64
+}
+
+// ExecutionResult - результат успешного исполнения программы.
+type ExecutionResult struct {
+ ScriptRunID string
+ Postings    []Posting
+}
+
+// Ledger - минимальный интерфейс хранилища, нужный Machine: узнать баланс
+// счета в минорных единицах и атомарно применить набор проводок. В основном
+// пакете реализуется адаптером поверх Storage (см. script_runner.go).
+type Ledger interface {
+ BalanceCents(accountID string) (int64, error)
+ // ApplyPostings должен применить все проводки как одну атомарную операцию:
+ // при любой ошибке ни одна проводка не должна сохраниться.
+ ApplyPostings(postings []Posting) error
+}
+
+// Machine исполняет скомпилированные программы.
+type Machine struct{}
+
+func NewMachine() *Machine {
+ return &Machine{}
+}
+
+// Execute проигрывает инструкции программы и на SEND атомарно применяет
+// получившиеся проводки через ledger. Перед применением проверяет, что на
+// source-счете достаточно средств на всю сумму - чтобы либо прошли все
+// проводки, либо ни одна.
+func (m *Machine) Execute(program *Program, ledger Ledger) (*ExecutionResult, error) {
+ var monetary Monetary
+ var source string
+ var destinations []destAllotment
+ var pendingPortion *Portion
+
+ for _, instr := range program.Instructions {
+  switch instr.Op {
+  case OpPushMonetary:
+   monetary = instr.Amount
+
+  case OpPushAccount:
+   switch instr.Role {
+   case AccountRoleSource:
+    source = instr.Account
+   case AccountRoleDestination:
+    if pendingPortion == nil {
+     return nil, fmt.Errorf("script: PUSH_ACCOUNT(destination) без предшествующего ALLOT")
+    }
+    destinations = append(destinations, destAllotment{portion: *pendingPortion, account: instr.Account})
+    pendingPortion = nil
+   }
+
+  case OpAllot:
+   portion := instr.Portion
+   pendingPortion = &portion
+
+  case OpSend:
+   return m.send(monetary, source, destinations, ledger)
+  }
+ }
+
+ return nil, fmt.Errorf("script: в программе отсутствует инструкция SEND")
+}
+
+type destAllotment struct {
+ portion Portion
+ account string
+}
+
+func (m *Machine) send(monetary Monetary, source string, destinations []destAllotment, ledger Ledger) (*ExecutionResult, error) {
+ if source == "" {
+  return nil, fmt.Errorf("script: не указан source-счет")
+ }
+ if len(destinations) == 0 {
+  return nil, fmt.Errorf("script: не указан destination")
+ }
+
+ amounts, err := allocate(monetary.AmountCents, destinations)
+ if err != nil {
+  return nil, err
+ }
+
+ balance, err := ledger.BalanceCents(source)
+ if err != nil {
+  return nil, fmt.Errorf("script: получение баланса %s: %w", source, err)
+ }
+ if balance < monetary.AmountCents {
+  return nil, fmt.Errorf("script: недостаточно средств на счете %s: баланс %d, требуется %d", source, balance, monetary.AmountCents)
+ }
+
+ scriptRunID := generateRunID()
+ postings := make([]Posting, 0, len(destinations))
+ for i, dest := range destinations {
+  postings = append(postings, Posting{
+   ScriptRunID: scriptRunID,
+   Source:      source,
+   Destination: dest.account,
+   Currency:    monetary.Currency,
+   AmountCents: amounts[i],
+  })
+ }
+
+ if err := ledger.ApplyPostings(postings); err != nil {
+  return nil, err
+ }
+
+ return &ExecutionResult{ScriptRunID: scriptRunID, Postings: postings}, nil
+}
+
+// allocate распределяет total между destinations по их долям: явные проценты
+// считаются как total*numerator/denominator, а не более одной remaining-доли
+// получает весь оставшийся после процентов остаток. Без remaining-доли явные
+// доли должны в сумме давать ровно 100%; минорные единицы, потерянные при
+// округлении долей вниз, достаются последнему получателю.
+func allocate(total int64, destinations []destAllotment) ([]int64, error) {
+ amounts := make([]int64, len(destinations))
+ var allocated int64
+ remainingIdx := -1
+
+ for i, dest := range destinations {
+  if dest.portion.Remaining {
+   if remainingIdx != -1 {
+    return nil, fmt.Errorf("script: допускается не более одной remaining-доли")
+   }
+   remainingIdx = i
+   continue
+  }
+  share := total * dest.portion.Numerator / dest.portion.Denominator
+  amounts[i] = share
+  allocated += share
+ }
+
+ if remainingIdx != -1 {
+  amounts[remainingIdx] = total - allocated
+  if amounts[remainingIdx] < 0 {
+   return nil, fmt.Errorf("script: доли в сумме превышают 100%%")
+  }
+  return amounts, nil
+ }
+
+ portionSum := new(big.Rat)
+ for _, dest := range destinations {
+  portionSum.Add(portionSum, big.NewRat(dest.portion.Numerator, dest.portion.Denominator))
+ }
+ if portionSum.Cmp(big.NewRat(1, 1)) != 0 {
+  return nil, fmt.Errorf("script: доли должны в сумме составлять 100%%, получено %s%%", new(big.Rat).Mul(portionSum, big.NewRat(100, 1)).FloatString(2))
+ }
+ amounts[len(amounts)-1] += total - allocated
+ return amounts, nil
+}
+
+func generateRunID() string {
+ buf := make([]byte, 16)
+ if _, err := rand.Read(buf); err != nil {
+  return fmt.Sprintf("run-%p", buf)
+ }
+ return hex.EncodeToString(buf)
+}
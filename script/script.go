@@ -0,0 +1,316 @@
+// Package script реализует небольшой numscript-подобный DSL для атомарных
+// мультисчетных проводок: один скрипт описывает сумму, источник и разбивку
+// получателей (в процентах и/или остатком), а Machine исполняет его как одну
+// атомарную операцию - либо применяются все проводки, либо ни одной.
+package script
+
+import (
+ "fmt"
+ "strconv"
+ "strings"
+)
+
+// OpCode - код инструкции скомпилированной программы.
+type OpCode int
+
+const (
+ OpPushMonetary OpCode = iota
+ OpPushAccount
+ OpAllot
+ OpSend
+)
+
+func (op OpCode) String() string {
+ switch op {
+ case OpPushMonetary:
+  return "PUSH_MONETARY"
+ case OpPushAccount:
+  return "PUSH_ACCOUNT"
+ case OpAllot:
+  return "ALLOT"
+ case OpSend:
+  return "SEND"
+ default:
+  return "UNKNOWN"
+ }
+}
+
+// AccountRole различает, в какой роли счет был помещен в стек инструкцией
+// PUSH_ACCOUNT - источник или один из получателей.
+type AccountRole int
+
+const (
+ AccountRoleSource AccountRole = iota
+ AccountRoleDestination
+)
+
+// Portion - доля суммы, выделяемая получателю: либо явная дробь (percent
+// "70%" хранится как Numerator=70, Denominator=100), либо остаток ("remaining").
+type Portion struct {
+ Remaining   bool
+ Numerator   int64
+ Denominator int64
+}
+
+// Instruction - одна инструкция инструкционного потока, производимого Compile.
+type Instruction struct {
+ Op      OpCode
+ Account string
+ Role    AccountRole
+ Amount  Monetary
+ Portion Portion
+}
+
+// Monetary - сумма в конкретной валюте, хранится в минорных единицах (центах),
+// по тому же соглашению, что и SQLStorage (см. storage_sql.go в основном пакете).
+type Monetary struct {
+ Currency    string
+ AmountCents int64
+}
+
+// Program - скомпилированный скрипт: плоский поток инструкций, который
+// Machine.Execute проигрывает по порядку.
+type Program struct {
+ Instructions []Instruction
+}
+
+// Compile разбирает исходный текст скрипта в Program. Поддерживается ровно
+// одна форма - "send [CUR AMOUNT] (source = @acc destination = ...)", где
+// destination - это либо один счет, либо блок "{ ... }" с процентными долями
+// и необязательной долей "remaining".
+func Compile(src string) (*Program, error) {
+ p := &parser{tokens: lex(src)}
+ prog, err := p.parseSend()
+ if err != nil {
+  return nil, err
+ }
+ if !p.atEnd() {
+  return nil, fmt.Errorf("script: лишние данные после скрипта: %q", p.peek().text)
+ }
+ return prog, nil
+}
+
+type parser struct {
+ tokens []token
+ pos    int
+}
+
+func (p *parser) peek() token {
+ if p.pos >= len(p.tokens) {
+  return token{kind: tokEOF}
+ }
+ return p.tokens[p.pos]
+}
+
+func (p *parser) atEnd() bool {
+ return p.peek().kind == tokEOF
+}
+
+func (p *parser) next() token {
+ t := p.peek()
+ p.pos++
+ return t
+}
+
+func (p *parser) expectIdent(word string) error {
+ t := p.next()
+ if t.kind != tokIdent || !strings.EqualFold(t.text, word) {
+  return fmt.Errorf("script: ожидалось %q, получено %q", word, t.text)
+ }
+ return nil
+}
+
+func (p *parser) expectSymbol(sym string) error {
+ t := p.next()
+ if t.kind != tokSymbol || t.text != sym {
+  return fmt.Errorf("script: ожидался символ %q, получено %q", sym, t.text)
+ }
+ return nil
+}
+
+// parseSend разбирает "send [CUR AMOUNT] ( source = ACCOUNT destination = DEST )".
+func (p *parser) parseSend() (*Program, error) {
+ if err := p.expectIdent("send"); err != nil {
+  return nil, err
+ }
+
+ monetary, err := p.parseMonetary()
+ if err != nil {
+  return nil, err
+ }
+
+ if err := p.expectSymbol("("); err != nil {
+  return nil, err
+ }
+ if err := p.expectIdent("source"); err != nil {
+  return nil, err
+ }
+ if err := p.expectSymbol("="); err != nil {
+  return nil, err
+ }
+ source, err := p.parseAccount()
+ if err != nil {
+  return nil, err
+ }
+
+ if err := p.expectIdent("destination"); err != nil {
+  return nil, err
+ }
+ if err := p.expectSymbol("="); err != nil {
+  return nil, err
+ }
+ destinations, err := p.parseDestination()
+ if err != nil {
+  return nil, err
+ }
+
+ if err := p.expectSymbol(")"); err != nil {
+  return nil, err
+ }
+
+ instructions := []Instruction{
+  {Op: OpPushMonetary, Amount: monetary},
+  {Op: OpPushAccount, Account: source, Role: AccountRoleSource},
+ }
+ for _, dest := range destinations {
+  instructions = append(instructions,
+   Instruction{Op: OpAllot, Portion: dest.portion},
+   Instruction{Op: OpPushAccount, Account: dest.account, Role: AccountRoleDestination},
+  )
+ }
+ instructions = append(instructions, Instruction{Op: OpSend})
+
+ return &Program{Instructions: instructions}, nil
+}
+
+// parseMonetary разбирает "[USD 50.00]" в Monetary{Currency: "USD", AmountCents: 5000}.
+func (p *parser) parseMonetary() (Monetary, error) {
+ if err := p.expectSymbol("["); err != nil {
+  return Monetary{}, err
+ }
+ currency := p.next()
+ if currency.kind != tokIdent {
+  return Monetary{}, fmt.Errorf("script: ожидался код валюты, получено %q", currency.text)
+ }
+ amount := p.next()
+ if amount.kind != tokNumber {
+  return Monetary{}, fmt.Errorf("script: ожидалась сумма, получено %q", amount.text)
+ }
+ cents, err := parseDecimalCents(amount.text)
+ if err != nil {
+  return Monetary{}, err
+ }
+ if err := p.expectSymbol("]"); err != nil {
+  return Monetary{}, err
+ }
+ return Monetary{Currency: currency.text, AmountCents: cents}, nil
+}
+
+// parseAccount разбирает "@acc:1" в строку "acc:1".
+func (p *parser) parseAccount() (string, error) {
+ if err := p.expectSymbol("@"); err != nil {
+  return "", err
+ }
+ var parts []string
+ for {
+  part := p.next()
+  if part.kind != tokIdent && part.kind != tokNumber {
+   return "", fmt.Errorf("script: некорректный идентификатор счета %q", part.text)
+  }
+  parts = append(parts, part.text)
+  if p.peek().kind == tokSymbol && p.peek().text == ":" {
+   p.next()
+   continue
+  }
+  break
+ }
+ return strings.Join(parts, ":"), nil
+}
+
+type destEntry struct {
+ portion Portion
+ account string
+}
+
+// parseDestination разбирает либо один счет (вся сумма ему), либо блок
+// "{ N% to @acc ... remaining to @acc }".
+func (p *parser) parseDestination() ([]destEntry, error) {
+ if p.peek().kind == tokSymbol && p.peek().text == "@" {
+  account, err := p.parseAccount()
+  if err != nil {
+   return nil, err
+  }
+  return []destEntry{{portion: Portion{Numerator: 100, Denominator: 100}, account: account}}, nil
+ }
+
+ if err := p.expectSymbol("{"); err != nil {
+  return nil, err
+ }
+
+ var entries []destEntry
+ for {
+  if p.peek().kind == tokSymbol && p.peek().text == "}" {
+   break
+  }
+
+  var portion Portion
+  if p.peek().kind == tokIdent && strings.EqualFold(p.peek().text, "remaining") {
+   p.next()
+   portion = Portion{Remaining: true}
+  } else {
+   num := p.next()
+   if num.kind != tokNumber {
+    return nil, fmt.Errorf("script: ожидалась доля (число%% или remaining), получено %q", num.text)
+   }
+   if err := p.expectSymbol("%"); err != nil {
+    return nil, err
+   }
+   percent, err := strconv.ParseInt(num.text, 10, 64)
+   if err != nil {
+    return nil, fmt.Errorf("script: некорректный процент %q: %w", num.text, err)
+   }
+   portion = Portion{Numerator: percent, Denominator: 100}
+  }
+
+  if err := p.expectIdent("to"); err != nil {
+   return nil, err
+  }
+  account, err := p.parseAccount()
+  if err != nil {
+   return nil, err
+  }
+
+  entries = append(entries, destEntry{portion: portion, account: account})
+ }
+
+ if err := p.expectSymbol("}"); err != nil {
+  return nil, err
+ }
+ if len(entries) == 0 {
+  return nil, fmt.Errorf("script: destination-блок не может быть пустым")
+ }
+ return entries, nil
+}
+
+// parseDecimalCents конвертирует десятичную строку суммы (например "50" или
+// "50.00" или "50.5") в количество минорных единиц (центов).
+func parseDecimalCents(text string) (int64, error) {
+ whole, frac, hasFrac := strings.Cut(text, ".")
+ wholeCents, err := strconv.ParseInt(whole, 10, 64)
+ if err != nil {
+  return 0, fmt.Errorf("script: некорректная сумма %q: %w", text, err)
+ }
+ cents := wholeCents * 100
+ if !hasFrac {
+  return cents, nil
+ }
+ for len(frac) < 2 {
+  frac += "0"
+ }
+ frac = frac[:2]
+ fracCents, err := strconv.ParseInt(frac, 10, 64)
+ if err != nil {
+  return 0, fmt.Errorf("script: некорректная сумма %q: %w", text, err)
+ }
+ return cents + fracCents, nil
+}
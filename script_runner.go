@@ -0,0 +1,145 @@
+package main
+
+import (
+ "bufio"
+ "fmt"
+ "os"
+ "strings"
+ "time"
+
+ "github.com/ulapogodina/Bankivskoe-prilogenie/script"
+)
+
+// ledgerAdapter реализует script.Ledger поверх BankApp, так что
+// numscript-подобные скрипты (package script) исполняются теми же
+// проводками двойной записи, что и остальной BankApp.
+type ledgerAdapter struct {
+ app *BankApp
+}
+
+func (l *ledgerAdapter) BalanceCents(accountID string) (int64, error) {
+ balance, err := l.app.GetBalance(accountID)
+ if err != nil {
+  return 0, err
+ }
+ return centsFromAmount(balance), nil
+}
+
+// ApplyPostings сначала проверяет, что каждый затронутый счет существует и
+// открыт, затем - что каждый destination-счет может быть кредитуемой стороной
+// (не закрыт и не Loan-счет, см. checkDepositable - иначе скрипт вроде
+// `send [USD 50.00] (source = @1 destination = @loanAcct)` обошел бы то же
+// ограничение, что и Deposit/postLeg), затем проверяет, что каждый
+// source-счет выдерживает сумму всех своих исходящих проводок в этом запуске
+// (а не каждую по отдельности - иначе разбивка одного send на несколько
+// проводок с общим источником могла бы обойти лимит), и только после этого
+// проводит все проводки одним атомарным вызовом
+// Storage.AppendPostingsIdempotent - так частично исполненный скрипт не может
+// провести деньги на несуществующий или недопустимый счет, либо превысить
+// лимит, на середине списка проводок. Вся последовательность выполняется под
+// app.postingMu (см. BankApp.postLeg), чтобы конкурентный Withdraw/Transfer по
+// тому же source-счету не мог проскочить между проверкой лимита и записью
+// проводок.
+func (l *ledgerAdapter) ApplyPostings(postings []script.Posting) error {
+ l.app.postingMu.Lock()
+ defer l.app.postingMu.Unlock()
+
+ touched := make(map[string]bool)
+ for _, posting := range postings {
+  for _, accountID := range []string{posting.Source, posting.Destination} {
+   if touched[accountID] {
+    continue
+   }
+   if _, err := l.app.storage.LoadAccount(accountID); err != nil {
+    return err
+   }
+   touched[accountID] = true
+  }
+ }
+
+ destinations := make(map[string]bool)
+ for _, posting := range postings {
+  if destinations[posting.Destination] {
+   continue
+  }
+  if err := l.app.checkDepositable(posting.Destination); err != nil {
+   return err
+  }
+  destinations[posting.Destination] = true
+ }
+
+ sourceTotals := make(map[string]float64)
+ for _, posting := range postings {
+  sourceTotals[posting.Source] += amountFromCents(posting.AmountCents)
+ }
+ for source, total := range sourceTotals {
+  if err := l.app.checkWithdrawable(source, total); err != nil {
+   return err
+  }
+ }
+
+ ledgerPostings := make([]Posting, 0, len(postings))
+ for i, posting := range postings {
+  // Отдельный referenceID на проводку, а не общий ScriptRunID - иначе вторая
+  // проводка с тем же источником внутри одного запуска скрипта была бы
+  // принята за дубликат уже примененной и молча пропущена (см.
+  // Storage.AppendPostingIdempotent).
+  referenceID := fmt.Sprintf("%s:%d", posting.ScriptRunID, i)
+  ledgerPostings = append(ledgerPostings, Posting{
+   ID:              referenceID,
+   DebitAccountID:  posting.Source,
+   CreditAccountID: posting.Destination,
+   AmountCents:     posting.AmountCents,
+   Timestamp:       time.Now(),
+   ReferenceID:     referenceID,
+  })
+ }
+
+ return l.app.storage.AppendPostingsIdempotent(ledgerPostings)
+}
+
+// RunScript компилирует и атомарно исполняет numscript-подобный скрипт над
+// счетами приложения.
+func (app *BankApp) RunScript(src string) (*script.ExecutionResult, error) {
+ program, err := script.Compile(src)
+ if err != nil {
+  return nil, err
+ }
+ return script.NewMachine().Execute(program, &ledgerAdapter{app: app})
+}
+
+// appRunScript читает скрипт со стандартного ввода построчно до первой пустой
+// строки после текста скрипта (пустые строки перед ним, включая остаток строки
+// с выбором пункта меню, пропускаются), затем исполняет его через
+// BankApp.RunScript.
+func appRunScript(bankApp *BankApp) {
+ fmt.Println("Введите скрипт (пример: send [USD 50.00] (source = @1 destination = { 70% to @2 30% to @3 })).")
+ fmt.Println("Завершите ввод пустой строкой:")
+
+ reader := bufio.NewReader(os.Stdin)
+ var lines []string
+ for {
+  line, err := reader.ReadString('\n')
+  trimmed := strings.TrimRight(line, "\r\n")
+  if trimmed == "" && len(lines) == 0 && err == nil {
+   continue
+  }
+  if trimmed != "" {
+   lines = append(lines, trimmed)
+  }
+  if trimmed == "" || err != nil {
+   break
+  }
+ }
+
+ result, err := bankApp.RunScript(strings.Join(lines, "\n"))
+ if err != nil {
+  fmt.Printf("Ошибка выполнения скрипта: %v\n", err)
+  return
+ }
+
+ fmt.Printf("Скрипт выполнен (ScriptRunID: %s), проводок: %d\n", result.ScriptRunID, len(result.Postings))
+ for _, posting := range result.Postings {
+  fmt.Printf("  %s -> %s: %d.%02d %s\n", posting.Source, posting.Destination, posting.AmountCents/100, posting.AmountCents%100, posting.Currency)
+ }
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+ "errors"
+ "fmt"
+ "math"
+ "strconv"
+ "time"
+
+ "gorm.io/driver/postgres"
+ "gorm.io/driver/sqlite"
+ "gorm.io/gorm"
+)
+
+// accountModel - GORM-модель метаданных счета. Баланс в ней не хранится - он
+// проецируется из postingModel (см. BankApp.GetBalance), поэтому модель
+// ограничена владельцем, клиентом, типом счета, его кредитным лимитом и
+// признаком закрытия. ID - суррогатный
+// автоинкрементный первичный ключ БД; AccountID - бизнес-идентификатор счета
+// (то, что видит BankApp), хранится отдельной уникальной колонкой, чтобы не
+// зависеть от того, что БД присвоит ключу именно при первой вставке.
+type accountModel struct {
+ ID               uint   `gorm:"primaryKey;autoIncrement"`
+ AccountID        string `gorm:"uniqueIndex;column:account_id"`
+ Owner            string
+ CustomerID       string
+ Type             string
+ CreditLimitCents int64
+ Closed           bool
+}
+
+func (accountModel) TableName() string {
+ return "accounts"
+}
+
+// postingModel - GORM-модель одной проводки двойной записи (append-only).
+// ReferenceID уникален, чтобы AppendPostingIdempotent мог опереться на
+// ограничение уникальности БД, а не только на проверку перед вставкой.
+type postingModel struct {
+ ID              string `gorm:"primaryKey"`
+ DebitAccountID  string `gorm:"index;column:debit_account_id"`
+ CreditAccountID string `gorm:"index;column:credit_account_id"`
+ AmountCents     int64
+ Timestamp       int64
+ ReferenceID     string `gorm:"uniqueIndex"`
+}
+
+func (postingModel) TableName() string {
+ return "postings"
+}
+
+// centsFromAmount и amountFromCents конвертируют между доменным float64 (как
+// в аргументах BankApp.Deposit/Withdraw) и минорными единицами, в которых их
+// хранят ledger и SQLStorage - это убирает накопление ошибок округления
+// чисел с плавающей точкой при многократных операциях над одним счетом.
+func centsFromAmount(amount float64) int64 {
+ return int64(math.Round(amount * 100))
+}
+
+func amountFromCents(cents int64) float64 {
+ return float64(cents) / 100
+}
+
+// SQLStorage - реализация Storage поверх GORM (SQLite или Postgres).
+type SQLStorage struct {
+ db *gorm.DB
+}
+
+// NewSQLStorage открывает соединение с БД по выбранному driver ("sqlite" или
+// "postgres") и dsn, и прогоняет автомиграцию схемы.
+func NewSQLStorage(dsn string, driver string) (*SQLStorage, error) {
+ var dialector gorm.Dialector
+ switch driver {
+ case "sqlite":
+  dialector = sqlite.Open(dsn)
+ case "postgres":
+  dialector = postgres.Open(dsn)
+ default:
+  return nil, fmt.Errorf("неизвестный драйвер SQL-хранилища: %s", driver)
+ }
+
+ db, err := gorm.Open(dialector, &gorm.Config{})
+ if err != nil {
+  return nil, fmt.Errorf("подключение к БД: %w", err)
+ }
+
+ if err := db.AutoMigrate(&accountModel{}, &postingModel{}); err != nil {
+  return nil, fmt.Errorf("миграция схемы: %w", err)
+ }
+
+ return &SQLStorage{db: db}, nil
+}
+
+// SaveAccount создает новый счет или обновляет существующий. Для новых
+// счетов ID счета - это автоинкрементный PK accountModel, присвоенный БД при
+// вставке (см. accountModel), а не что-то, что вычисляется в приложении, -
+// это и делает его коллизионно-свободным при конкурентных OpenAccount.
+func (s *SQLStorage) SaveAccount(account *Account) error {
+ if account.ID == "" {
+  model := accountModel{
+   Owner:            account.Owner,
+   CustomerID:       account.CustomerID,
+   Type:             string(account.Type),
+   CreditLimitCents: centsFromAmount(account.CreditLimit),
+   Closed:           account.Closed,
+  }
+  // Вставка и запись account_id идут одной транзакцией: иначе строка с
+  // временным пустым account_id могла бы столкнуться по уникальному индексу
+  // с такой же строкой параллельного OpenAccount.
+  return s.db.Transaction(func(tx *gorm.DB) error {
+   if err := tx.Create(&model).Error; err != nil {
+    return err
+   }
+   account.ID = strconv.FormatUint(uint64(model.ID), 10)
+   model.AccountID = account.ID
+   return tx.Model(&model).Update("account_id", account.ID).Error
+  })
+ }
+
+ return s.db.Model(&accountModel{}).Where("account_id = ?", account.ID).Updates(map[string]interface{}{
+  "owner":              account.Owner,
+  "customer_id":        account.CustomerID,
+  "type":               string(account.Type),
+  "credit_limit_cents": centsFromAmount(account.CreditLimit),
+  "closed":             account.Closed,
+ }).Error
+}
+
+func (s *SQLStorage) LoadAccount(accountID string) (*Account, error) {
+ var model accountModel
+ err := s.db.Where("account_id = ?", accountID).First(&model).Error
+ if err != nil {
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+   return nil, ErrAccountNotFound
+  }
+  return nil, err
+ }
+
+ return toAccount(model), nil
+}
+
+func (s *SQLStorage) GetAllAccounts() ([]*Account, error) {
+ var models []accountModel
+ if err := s.db.Find(&models).Error; err != nil {
+  return nil, err
+ }
+
+ accounts := make([]*Account, 0, len(models))
+ for _, model := range models {
+  accounts = append(accounts, toAccount(model))
+ }
+ return accounts, nil
+}
+
+// AppendPostingIdempotent перед вставкой ищет проводку с таким же
+// ReferenceID: если она уже есть, вставка пропускается (applied=false, без
+// ошибки). Если параллельный вызов вставит тот же ReferenceID между этой
+// проверкой и вставкой, уникальный индекс по ReferenceID отклонит вторую
+// вставку, и этот конфликт возвращается как ошибка.
+func (s *SQLStorage) AppendPostingIdempotent(posting Posting) (bool, error) {
+ applied := false
+ err := appendPosting(s.db, posting, &applied)
+ return applied, err
+}
+
+// AppendPostingsIdempotent проводит postings в одной транзакции БД: если
+// appendPosting вернет ошибку на любой из них, gorm.DB.Transaction откатывает
+// уже вставленные в рамках этого вызова, так что частично исполненный скрипт
+// (см. script_runner.go) не может провести часть проводок.
+func (s *SQLStorage) AppendPostingsIdempotent(postings []Posting) error {
+ return s.db.Transaction(func(tx *gorm.DB) error {
+  for _, posting := range postings {
+   if err := appendPosting(tx, posting, nil); err != nil {
+    return err
+   }
+  }
+  return nil
+ })
+}
+
+// appendPosting вставляет posting через db, пропуская вставку (и выставляя
+// *applied=false), если проводка с таким ReferenceID уже существует.
+func appendPosting(db *gorm.DB, posting Posting, applied *bool) error {
+ model := postingModel{
+  ID:              posting.ID,
+  DebitAccountID:  posting.DebitAccountID,
+  CreditAccountID: posting.CreditAccountID,
+  AmountCents:     posting.AmountCents,
+  Timestamp:       posting.Timestamp.Unix(),
+  ReferenceID:     posting.ReferenceID,
+ }
+
+ if posting.ReferenceID != "" {
+  var existing postingModel
+  err := db.Where("reference_id = ?", posting.ReferenceID).First(&existing).Error
+  if err == nil {
+   return nil
+  }
+  if !errors.Is(err, gorm.ErrRecordNotFound) {
+   return err
+  }
+ }
+
+ if err := db.Create(&model).Error; err != nil {
+  return err
+ }
+ if applied != nil {
+  *applied = true
+ }
+ return nil
+}
+
+func (s *SQLStorage) ListPostings(accountID string) ([]Posting, error) {
+ var models []postingModel
+ err := s.db.Where("debit_account_id = ? OR credit_account_id = ?", accountID, accountID).
+  Order("timestamp asc").Find(&models).Error
+ if err != nil {
+  return nil, err
+ }
+
+ postings := make([]Posting, 0, len(models))
+ for _, model := range models {
+  postings = append(postings, Posting{
+   ID:              model.ID,
+   DebitAccountID:  model.DebitAccountID,
+   CreditAccountID: model.CreditAccountID,
+   AmountCents:     model.AmountCents,
+   Timestamp:       time.Unix(model.Timestamp, 0),
+   ReferenceID:     model.ReferenceID,
+  })
+ }
+ return postings, nil
+}
+
+func (s *SQLStorage) Close() error {
+ sqlDB, err := s.db.DB()
+ if err != nil {
+  return err
+ }
+ return sqlDB.Close()
+}
+
+// toAccount переводит GORM-модель обратно в доменный Account.
+func toAccount(model accountModel) *Account {
+ return &Account{
+  ID:          model.AccountID,
+  Owner:       model.Owner,
+  CustomerID:  model.CustomerID,
+  Type:        AccountType(model.Type),
+  CreditLimit: amountFromCents(model.CreditLimitCents),
+  Closed:      model.Closed,
+ }
+}
+
+// newStorage создает реализацию Storage по имени, выбранному флагом -storage.
+func newStorage(kind string, dsn string) (Storage, error) {
+ switch kind {
+ case "memory":
+  return NewInMemoryStorage(), nil
+ case "sqlite":
+  return NewSQLStorage(dsn, "sqlite")
+ case "postgres":
+  return NewSQLStorage(dsn, "postgres")
+ default:
+  return nil, fmt.Errorf("неизвестное хранилище: %s (ожидается memory|sqlite|postgres)", kind)
+ }
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+ "context"
+ "crypto/rand"
+ "encoding/hex"
+ "errors"
+ "fmt"
+ "sync"
+ "time"
+)
+
+// Ошибки саги переводов
+var (
+ ErrOrderNotFound = errors.New("заявка на перевод не найдена")
+ ErrOrderConflict = errors.New("заявка на перевод изменена параллельно")
+)
+
+// TransferStatus - состояние заявки на перевод между счетами.
+type TransferStatus string
+
+const (
+ TransferStarted     TransferStatus = "STARTED"
+ TransferWithdrawing TransferStatus = "WITHDRAWING"
+ TransferDepositing  TransferStatus = "DEPOSITING"
+ TransferRefunding   TransferStatus = "REFUNDING"
+ TransferSucceeded   TransferStatus = "SUCCEEDED"
+ TransferFailed      TransferStatus = "FAILED"
+)
+
+// isTerminal сообщает, что заявка больше не нуждается в продвижении саги.
+func (s TransferStatus) isTerminal() bool {
+ return s == TransferSucceeded || s == TransferFailed
+}
+
+// TransferOrder - заявка на перевод, продвигаемая сагой пошагово. ID заявки
+// совпадает с ReferenceID и используется для дедупликации проводок (см.
+// Storage.AppendPostingIdempotent), поэтому Resume может безопасно повторно
+// проиграть незавершенную заявку после перезапуска процесса.
+type TransferOrder struct {
+ ID            string
+ FromAccountID string
+ ToAccountID   string
+ Amount        float64
+ Status        TransferStatus
+ ReferenceID   string
+ FailureReason string
+ CreatedAt     time.Time
+ UpdatedAt     time.Time
+}
+
+// refundReferenceID - ключ идемпотентности для возврата средств отправителю,
+// отдельный от ReferenceID самого перевода, чтобы возврат не считался
+// повторным зачислением исходного перевода.
+func (o *TransferOrder) refundReferenceID() string {
+ return o.ReferenceID + ":refund"
+}
+
+// TransferStore хранит заявки на перевод и позволяет продвигать их состояние
+// атомарно, даже если процесс перезапускается между шагами саги.
+type TransferStore interface {
+ CreateOrder(ctx context.Context, order *TransferOrder) error
+ GetOrder(ctx context.Context, orderID string) (*TransferOrder, error)
+ // CompareAndSwap переводит заявку orderID в newState, только если её текущее
+ // состояние равно expectedState. Возвращает ErrOrderConflict, если это не так.
+ CompareAndSwap(ctx context.Context, orderID string, newState, expectedState TransferStatus) error
+ // ListNonTerminal возвращает заявки, которые не достигли Succeeded/Failed -
+ // их должен продолжить Resume после перезапуска процесса.
+ ListNonTerminal(ctx context.Context) ([]*TransferOrder, error)
+}
+
+// InMemoryTransferStore - реализация TransferStore в памяти, по аналогии с
+// InMemoryStorage. Как и в InMemoryStorage, операции защищены мьютексом -
+// здесь это обязательно, потому что CompareAndSwap обязан быть атомарным.
+type InMemoryTransferStore struct {
+ mu     sync.Mutex
+ orders map[string]*TransferOrder
+}
+
+func NewInMemoryTransferStore() *InMemoryTransferStore {
+ return &InMemoryTransferStore{orders: make(map[string]*TransferOrder)}
+}
+
+func (s *InMemoryTransferStore) CreateOrder(ctx context.Context, order *TransferOrder) error {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ orderCopy := *order
+ s.orders[order.ID] = &orderCopy
+ return nil
+}
+
+func (s *InMemoryTransferStore) GetOrder(ctx context.Context, orderID string) (*TransferOrder, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ order, exists := s.orders[orderID]
+ if !exists {
+  return nil, ErrOrderNotFound
+ }
+ orderCopy := *order
+ return &orderCopy, nil
+}
+
+func (s *InMemoryTransferStore) CompareAndSwap(ctx context.Context, orderID string, newState, expectedState TransferStatus) error {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ order, exists := s.orders[orderID]
+ if !exists {
+  return ErrOrderNotFound
+ }
+ if order.Status != expectedState {
+  return ErrOrderConflict
+ }
+ order.Status = newState
+ order.UpdatedAt = time.Now()
+ return nil
+}
+
+func (s *InMemoryTransferStore) ListNonTerminal(ctx context.Context) ([]*TransferOrder, error) {
+ s.mu.Lock()
+ defer s.mu.Unlock()
+
+ var orders []*TransferOrder
+ for _, order := range s.orders {
+  if !order.Status.isTerminal() {
+   orderCopy := *order
+   orders = append(orders, &orderCopy)
+  }
+ }
+ return orders, nil
+}
+
+// generateReferenceID создает случайный идемпотентный ключ для новой заявки
+// на перевод.
+func generateReferenceID() string {
+ buf := make([]byte, 16)
+ if _, err := rand.Read(buf); err != nil {
+  // crypto/rand не должен отказывать на обычной системе; на крайний случай
+  // используем метку времени, чтобы не уронить перевод из-за этого.
+  return fmt.Sprintf("tx-%d", time.Now().UnixNano())
+ }
+ return hex.EncodeToString(buf)
+}
+
+// Transfer запускает сагу перевода между счетами fromAccountID и toAccountID:
+// создает TransferOrder и пошагово продвигает её (Started -> Withdrawing ->
+// Depositing -> Succeeded, либо Refunding -> Failed при сбое после списания).
+// Каждый шаг идемпотентен благодаря ReferenceID заявки, поэтому если процесс
+// упадет между шагами, Resume(ctx) безопасно продолжит ровно с того места,
+// где сага остановилась, и деньги не "потеряются" между двумя SaveAccount.
+func (app *BankApp) Transfer(ctx context.Context, fromAccountID, toAccountID string, amount float64) (*TransferOrder, error) {
+ if amount <= 0 {
+  return nil, ErrInvalidAmount
+ }
+ if fromAccountID == toAccountID {
+  return nil, ErrSameAccountTransfer
+ }
+
+ referenceID := generateReferenceID()
+ now := time.Now()
+ order := &TransferOrder{
+  ID:            referenceID,
+  FromAccountID: fromAccountID,
+  ToAccountID:   toAccountID,
+  Amount:        amount,
+  Status:        TransferStarted,
+  ReferenceID:   referenceID,
+  CreatedAt:     now,
+  UpdatedAt:     now,
+ }
+
+ if err := app.transferStore.CreateOrder(ctx, order); err != nil {
+  return nil, err
+ }
+
+ err := app.advanceTransfer(ctx, order)
+ return order, err
+}
+
+// Resume просматривает незавершенные заявки на перевод и доводит каждую до
+// терминального состояния (Succeeded или Failed). Предназначен для вызова при
+// старте процесса, чтобы подхватить переводы, прерванные крашем. Ошибка одной
+// заявки не прерывает обход остальных: ошибки собираются и возвращаются вместе.
+func (app *BankApp) Resume(ctx context.Context) error {
+ orders, err := app.transferStore.ListNonTerminal(ctx)
+ if err != nil {
+  return err
+ }
+ var errs []error
+ for _, order := range orders {
+  if err := app.advanceTransfer(ctx, order); err != nil {
+   errs = append(errs, fmt.Errorf("восстановление заявки %s: %w", order.ID, err))
+  }
+ }
+ return errors.Join(errs...)
+}
+
+// advanceTransfer продвигает заявку шаг за шагом до терминального состояния.
+// Withdrawing и Depositing - это два отдельных проводки двойной записи через
+// externalAccountID, служащий временным "транзитом" для денег в пути: сага не
+// может провести одну проводку source->destination одним шагом, потому что
+// сами шаги должны быть независимо идемпотентными и восстанавливаемыми после
+// краша между ними.
+func (app *BankApp) advanceTransfer(ctx context.Context, order *TransferOrder) error {
+ for !order.Status.isTerminal() {
+  switch order.Status {
+  case TransferStarted:
+   if err := app.transition(ctx, order, TransferWithdrawing, TransferStarted); err != nil {
+    return err
+   }
+
+  case TransferWithdrawing:
+   if err := app.postLeg(order.FromAccountID, externalAccountID, order.Amount, order.ReferenceID); err != nil {
+    return app.failOrder(ctx, order, err)
+   }
+   if err := app.transition(ctx, order, TransferDepositing, TransferWithdrawing); err != nil {
+    return err
+   }
+
+  case TransferDepositing:
+   // Проводка Withdrawing->Depositing через externalAccountID устроена
+   // одинаково с обычным Deposit (EXTERNAL -> счет), поэтому она обязана
+   // соблюдать то же ограничение ErrDirectLoanDeposit, иначе перевод стал бы
+   // лазейкой для прямого пополнения Loan-счета в обход BankApp.Deposit.
+   toAccount, err := app.storage.LoadAccount(order.ToAccountID)
+   if err == nil && toAccount.Type == AccountTypeLoan {
+    err = ErrDirectLoanDeposit
+   }
+   if err != nil {
+    if err := app.transition(ctx, order, TransferRefunding, TransferDepositing); err != nil {
+     return err
+    }
+    order.FailureReason = err.Error()
+    continue
+   }
+   if err := app.postLeg(externalAccountID, order.ToAccountID, order.Amount, order.ReferenceID+":deposit"); err != nil {
+    if err := app.transition(ctx, order, TransferRefunding, TransferDepositing); err != nil {
+     return err
+    }
+    order.FailureReason = err.Error()
+    continue
+   }
+   if err := app.transition(ctx, order, TransferSucceeded, TransferDepositing); err != nil {
+    return err
+   }
+
+  case TransferRefunding:
+   if err := app.postLeg(externalAccountID, order.FromAccountID, order.Amount, order.refundReferenceID()); err != nil {
+    return err
+   }
+   if err := app.transition(ctx, order, TransferFailed, TransferRefunding); err != nil {
+    return err
+   }
+  }
+ }
+ return nil
+}
+
+// transition продвигает заявку в TransferStore и зеркалит новое состояние в
+// переданный order, чтобы вызывающий код сразу видел актуальный статус.
+func (app *BankApp) transition(ctx context.Context, order *TransferOrder, newState, expectedState TransferStatus) error {
+ if err := app.transferStore.CompareAndSwap(ctx, order.ID, newState, expectedState); err != nil {
+  return err
+ }
+ order.Status = newState
+ order.UpdatedAt = time.Now()
+ return nil
+}
+
+// failOrder помечает заявку как требующую возврата средств отправителю
+// (Refunding) и проводит возврат, если списание с ReferenceID заявки уже есть
+// в ledger (например, Resume повторяет шаг Withdrawing после краша), либо
+// сразу как Failed, если сбой произошел до списания.
+func (app *BankApp) failOrder(ctx context.Context, order *TransferOrder, cause error) error {
+ order.FailureReason = cause.Error()
+
+ if order.Status == TransferWithdrawing {
+  postings, err := app.storage.ListPostings(order.FromAccountID)
+  if err != nil {
+   return err
+  }
+  for _, p := range postings {
+   if p.ReferenceID == order.ReferenceID {
+    if err := app.transition(ctx, order, TransferRefunding, TransferWithdrawing); err != nil {
+     return err
+    }
+    if err := app.advanceTransfer(ctx, order); err != nil {
+     return err
+    }
+    return cause
+   }
+  }
+  if err := app.transition(ctx, order, TransferFailed, TransferWithdrawing); err != nil {
+   return err
+  }
+  return cause
+ }
+
+ if err := app.transition(ctx, order, TransferFailed, order.Status); err != nil {
+  return err
+ }
+ return cause
+}